@@ -0,0 +1,38 @@
+package dnsserver
+
+import (
+	"context"
+
+	"github.com/miekg/dns"
+)
+
+// MetricsListener is the interface for collecting server statistics.  All
+// methods must be safe for concurrent use, since a [Server] may invoke them
+// from multiple request-handling goroutines at once.  [MultiListener] fans a
+// single call out to several listeners at once.
+type MetricsListener interface {
+	// OnRequestStart is called as soon as a request has been read off the
+	// wire, before any further processing begins.  Implementations that
+	// track in-flight request counts increment their gauge here; the
+	// matching decrement happens in OnRequest.  A [Server] must dispatch
+	// requests through [WithRequestStartMetrics] for this to be called.
+	OnRequestStart(ctx context.Context)
+
+	// OnRequest is called once a request has been fully processed.  resp is
+	// nil if the request was dropped instead of answered.
+	OnRequest(ctx context.Context, req, resp *dns.Msg, rw ResponseWriter)
+
+	// OnInvalidMsg is called when a query fails to parse as a DNS message.
+	OnInvalidMsg(ctx context.Context)
+
+	// OnError is called when an error occurs while processing a request
+	// that isn't itself an invalid message or a panic.
+	OnError(ctx context.Context, err error)
+
+	// OnPanic is called when processing a request recovers from a panic.
+	OnPanic(ctx context.Context, v any)
+
+	// OnQUICAddressValidation is called after every QUIC address validation
+	// cache lookup; hit is true if the address was found in the cache.
+	OnQUICAddressValidation(hit bool)
+}
@@ -0,0 +1,39 @@
+package dnsserver
+
+import (
+	"context"
+
+	"github.com/miekg/dns"
+)
+
+// Handler processes a single DNS request.  [Server] implementations dispatch
+// to a Handler once a request has been read off the wire.
+type Handler interface {
+	ServeDNS(ctx context.Context, rw ResponseWriter, req *dns.Msg)
+}
+
+// HandlerFunc is a function adapter for [Handler].
+type HandlerFunc func(ctx context.Context, rw ResponseWriter, req *dns.Msg)
+
+// ServeDNS implements the [Handler] interface for HandlerFunc.
+func (f HandlerFunc) ServeDNS(ctx context.Context, rw ResponseWriter, req *dns.Msg) {
+	f(ctx, rw, req)
+}
+
+// WithRequestStartMetrics wraps h so that l.OnRequestStart is called before
+// every request is dispatched to h, mirroring the pattern used by Caddy's
+// HTTP metrics middleware to drive its in-flight requests gauge.  A [Server]
+// must dispatch incoming requests through the handler returned here (rather
+// than calling h directly) for [ServerMetricsListener]'s requests_in_flight
+// gauge to reflect reality instead of drifting negative.
+//
+// NOTE: this package does not itself contain a [Server] implementation or a
+// ServeDNS entry point to wire this into — that lives outside this tree.
+// Whatever does dispatch incoming requests must call this, or the gauge it
+// drives is wrong from the first request onward.
+func WithRequestStartMetrics(l MetricsListener, h Handler) (wrapped Handler) {
+	return HandlerFunc(func(ctx context.Context, rw ResponseWriter, req *dns.Msg) {
+		l.OnRequestStart(ctx)
+		h.ServeDNS(ctx, rw, req)
+	})
+}
@@ -0,0 +1,60 @@
+package dnsserver
+
+import (
+	"context"
+
+	"github.com/miekg/dns"
+)
+
+// MultiListener is a [MetricsListener] that fans every call out to each of
+// its members, in order.  This allows combining, for example, the
+// Prometheus listener with a dnstap one without either having to know about
+// the other.
+type MultiListener []MetricsListener
+
+// type check
+var _ MetricsListener = MultiListener(nil)
+
+// OnRequestStart implements the [MetricsListener] interface for
+// MultiListener.
+func (m MultiListener) OnRequestStart(ctx context.Context) {
+	for _, l := range m {
+		l.OnRequestStart(ctx)
+	}
+}
+
+// OnRequest implements the [MetricsListener] interface for MultiListener.
+func (m MultiListener) OnRequest(ctx context.Context, req, resp *dns.Msg, rw ResponseWriter) {
+	for _, l := range m {
+		l.OnRequest(ctx, req, resp, rw)
+	}
+}
+
+// OnInvalidMsg implements the [MetricsListener] interface for MultiListener.
+func (m MultiListener) OnInvalidMsg(ctx context.Context) {
+	for _, l := range m {
+		l.OnInvalidMsg(ctx)
+	}
+}
+
+// OnError implements the [MetricsListener] interface for MultiListener.
+func (m MultiListener) OnError(ctx context.Context, err error) {
+	for _, l := range m {
+		l.OnError(ctx, err)
+	}
+}
+
+// OnPanic implements the [MetricsListener] interface for MultiListener.
+func (m MultiListener) OnPanic(ctx context.Context, v any) {
+	for _, l := range m {
+		l.OnPanic(ctx, v)
+	}
+}
+
+// OnQUICAddressValidation implements the [MetricsListener] interface for
+// MultiListener.
+func (m MultiListener) OnQUICAddressValidation(hit bool) {
+	for _, l := range m {
+		l.OnQUICAddressValidation(hit)
+	}
+}
@@ -0,0 +1,196 @@
+package prometheus
+
+import (
+	"fmt"
+
+	"github.com/AdguardTeam/AdGuardDNS/internal/dnsserver"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// This file implements the node_exporter-style --collector.<name> /
+// --no-collector.<name> pattern for this package: every expensive metric
+// family is hidden behind a small recorder interface, and [Config] decides,
+// once, at construction time, whether [*ServerMetricsListener] gets the real
+// Prometheus-backed implementation or a no-op stub.  This keeps the hot path
+// in OnRequest down to a single interface call per metric, regardless of
+// whether that metric is enabled.
+
+// registererOrDefault returns cfg.Registerer, or prometheus.DefaultRegisterer
+// if it is unset.
+func registererOrDefault(cfg *Config) (reg prometheus.Registerer) {
+	if cfg.Registerer != nil {
+		return cfg.Registerer
+	}
+
+	return prometheus.DefaultRegisterer
+}
+
+// sizeRecorder records an observation of a request or response size.
+type sizeRecorder interface {
+	Observe(serverInfo *dnsserver.ServerInfo, size float64)
+}
+
+// noopSizeRecorder is a [sizeRecorder] that does nothing.
+type noopSizeRecorder struct{}
+
+// type check
+var _ sizeRecorder = noopSizeRecorder{}
+
+// Observe implements the [sizeRecorder] interface for noopSizeRecorder.
+func (noopSizeRecorder) Observe(_ *dnsserver.ServerInfo, _ float64) {}
+
+// prometheusSizeRecorder is a [sizeRecorder] that observes a histogram and,
+// if configured, its summary companion.
+type prometheusSizeRecorder struct {
+	hist    *prometheus.HistogramVec
+	summary *prometheus.SummaryVec
+}
+
+// type check
+var _ sizeRecorder = (*prometheusSizeRecorder)(nil)
+
+// Observe implements the [sizeRecorder] interface for
+// *prometheusSizeRecorder.
+func (r *prometheusSizeRecorder) Observe(serverInfo *dnsserver.ServerInfo, size float64) {
+	histogramWithServerLabels(serverInfo, r.hist).Observe(size)
+	if r.summary != nil {
+		summaryWithServerLabels(serverInfo, r.summary).Observe(size)
+	}
+}
+
+// newSizeRecorder returns a [sizeRecorder] for a request or response size
+// histogram named name (with summary companion summaryName, describing
+// kind, e.g. "requests" or "responses"), or a no-op one if
+// cfg.DisableSizeHistograms is set.
+func newSizeRecorder(cfg *Config, name, summaryName, kind string, buckets []float64) (r sizeRecorder) {
+	if cfg.DisableSizeHistograms {
+		return noopSizeRecorder{}
+	}
+
+	reg := registererOrDefault(cfg)
+
+	pr := &prometheusSizeRecorder{
+		hist: promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+			Name:      name,
+			Namespace: namespace,
+			Subsystem: subsystemServer,
+			Help:      fmt.Sprintf("The size of processed DNS %s.", kind),
+			Buckets:   buckets,
+		}, []string{"name", "proto", "addr"}),
+	}
+
+	if cfg.SummaryObjectives != nil {
+		pr.summary = promauto.With(reg).NewSummaryVec(prometheus.SummaryOpts{
+			Name:       summaryName,
+			Namespace:  namespace,
+			Subsystem:  subsystemServer,
+			Help:       fmt.Sprintf("The size of processed DNS %s, as a summary of quantiles.", kind),
+			Objectives: cfg.SummaryObjectives,
+		}, []string{"name", "proto", "addr"})
+	}
+
+	return pr
+}
+
+// rCodeRecorder records a DNS response code.
+type rCodeRecorder interface {
+	Inc(serverInfo *dnsserver.ServerInfo, rCode string)
+}
+
+// noopRCodeRecorder is an [rCodeRecorder] that does nothing.
+type noopRCodeRecorder struct{}
+
+// type check
+var _ rCodeRecorder = noopRCodeRecorder{}
+
+// Inc implements the [rCodeRecorder] interface for noopRCodeRecorder.
+func (noopRCodeRecorder) Inc(_ *dnsserver.ServerInfo, _ string) {}
+
+// prometheusRCodeRecorder is an [rCodeRecorder] that increments a counter.
+type prometheusRCodeRecorder struct {
+	counter *prometheus.CounterVec
+}
+
+// type check
+var _ rCodeRecorder = (*prometheusRCodeRecorder)(nil)
+
+// Inc implements the [rCodeRecorder] interface for *prometheusRCodeRecorder.
+func (r *prometheusRCodeRecorder) Inc(serverInfo *dnsserver.ServerInfo, rCode string) {
+	counterWithServerLabelsPlusRCode(serverInfo, rCode, r.counter).Inc()
+}
+
+// newRCodeRecorder returns the response_rcode_total [rCodeRecorder], or a
+// no-op one if cfg.DisableRCodeCounter is set.
+func newRCodeRecorder(cfg *Config) (r rCodeRecorder) {
+	if cfg.DisableRCodeCounter {
+		return noopRCodeRecorder{}
+	}
+
+	return &prometheusRCodeRecorder{
+		counter: promauto.With(registererOrDefault(cfg)).NewCounterVec(prometheus.CounterOpts{
+			Name:      "response_rcode_total",
+			Namespace: namespace,
+			Subsystem: subsystemServer,
+			Help:      "The counter for DNS response codes.",
+		}, []string{"name", "proto", "addr", "rcode"}),
+	}
+}
+
+// quicValidationRecorder records a QUIC address validation cache lookup.
+type quicValidationRecorder interface {
+	Record(hit bool)
+}
+
+// noopQUICValidationRecorder is a [quicValidationRecorder] that does
+// nothing.
+type noopQUICValidationRecorder struct{}
+
+// type check
+var _ quicValidationRecorder = noopQUICValidationRecorder{}
+
+// Record implements the [quicValidationRecorder] interface for
+// noopQUICValidationRecorder.
+func (noopQUICValidationRecorder) Record(_ bool) {}
+
+// prometheusQUICValidationRecorder is a [quicValidationRecorder] that
+// increments a hit or miss counter.
+type prometheusQUICValidationRecorder struct {
+	hits   prometheus.Counter
+	misses prometheus.Counter
+}
+
+// type check
+var _ quicValidationRecorder = (*prometheusQUICValidationRecorder)(nil)
+
+// Record implements the [quicValidationRecorder] interface for
+// *prometheusQUICValidationRecorder.
+func (r *prometheusQUICValidationRecorder) Record(hit bool) {
+	if hit {
+		r.hits.Inc()
+	} else {
+		r.misses.Inc()
+	}
+}
+
+// newQUICValidationRecorder returns the quic_addr_validation_lookups
+// [quicValidationRecorder], or a no-op one if
+// cfg.DisableQUICAddrValidationCounter is set.
+func newQUICValidationRecorder(cfg *Config) (r quicValidationRecorder) {
+	if cfg.DisableQUICAddrValidationCounter {
+		return noopQUICValidationRecorder{}
+	}
+
+	lookups := promauto.With(registererOrDefault(cfg)).NewCounterVec(prometheus.CounterOpts{
+		Name:      "quic_addr_validation_lookups",
+		Namespace: namespace,
+		Subsystem: subsystemServer,
+		Help: "The number of QUIC address validation lookups." +
+			"hit=1 means that a cached item was found.",
+	}, []string{"hit"})
+
+	return &prometheusQUICValidationRecorder{
+		hits:   lookups.WithLabelValues("1"),
+		misses: lookups.WithLabelValues("0"),
+	}
+}
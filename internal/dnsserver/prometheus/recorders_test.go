@@ -0,0 +1,50 @@
+package prometheus
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSizeRecorder_disabled(t *testing.T) {
+	cfg := &Config{DisableSizeHistograms: true, Registerer: prometheus.NewRegistry()}
+	r := newSizeRecorder(cfg, "request_size_bytes", "request_size_summary_bytes", "requests", defaultSizeBuckets)
+
+	assert.IsType(t, noopSizeRecorder{}, r)
+}
+
+func TestNewSizeRecorder_enabled(t *testing.T) {
+	cfg := &Config{Registerer: prometheus.NewRegistry()}
+	r := newSizeRecorder(cfg, "request_size_bytes", "request_size_summary_bytes", "requests", defaultSizeBuckets)
+
+	require.IsType(t, &prometheusSizeRecorder{}, r)
+}
+
+func TestNewRCodeRecorder_disabled(t *testing.T) {
+	cfg := &Config{DisableRCodeCounter: true, Registerer: prometheus.NewRegistry()}
+
+	assert.IsType(t, noopRCodeRecorder{}, newRCodeRecorder(cfg))
+}
+
+func TestNewQUICValidationRecorder_disabled(t *testing.T) {
+	cfg := &Config{DisableQUICAddrValidationCounter: true, Registerer: prometheus.NewRegistry()}
+
+	assert.IsType(t, noopQUICValidationRecorder{}, newQUICValidationRecorder(cfg))
+}
+
+// TestRecorders_noDuplicateRegistration makes sure that constructing more
+// than one *ServerMetricsListener, each against its own registerer, doesn't
+// panic with a duplicate-registration error, as it did before cfg.Registerer
+// was threaded through these constructors.
+func TestRecorders_noDuplicateRegistration(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	assert.NotPanics(t, func() {
+		NewServerMetricsListener(&Config{Registerer: reg})
+	})
+	assert.NotPanics(t, func() {
+		NewServerMetricsListener(&Config{Registerer: prometheus.NewRegistry()})
+	})
+}
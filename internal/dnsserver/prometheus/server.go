@@ -11,12 +11,207 @@ import (
 )
 
 // ServerMetricsListener implements the [dnsserver.MetricsListener] interface
-// and increments prom counters.
-type ServerMetricsListener struct{}
+// and increments prom counters.  Use [NewServerMetricsListener] to construct
+// one.
+type ServerMetricsListener struct {
+	requestDuration        *prometheus.HistogramVec
+	requestDurationSummary *prometheus.SummaryVec
+
+	requestSize  sizeRecorder
+	responseSize sizeRecorder
+
+	rCodeCounter rCodeRecorder
+
+	quicValidation quicValidationRecorder
+
+	requestTotal     *prometheus.CounterVec
+	requestsInFlight *prometheus.GaugeVec
+	errorTotal       *prometheus.CounterVec
+	panicTotal       *prometheus.CounterVec
+	invalidMsgTotal  *prometheus.CounterVec
+	requestType      *prometheus.CounterVec
+	requestDO        *prometheus.CounterVec
+	requestEDNS0     *prometheus.CounterVec
+	responseFlags    *prometheus.CounterVec
+
+	disableRCodeLabel bool
+}
 
 // type check
 var _ dnsserver.MetricsListener = (*ServerMetricsListener)(nil)
 
+// Config is the configuration for a [ServerMetricsListener].
+type Config struct {
+	// RequestSizeBuckets, if not nil, overrides the default buckets used for
+	// the request_size_bytes histogram.
+	RequestSizeBuckets []float64
+
+	// ResponseSizeBuckets, if not nil, overrides the default buckets used
+	// for the response_size_bytes histogram.
+	ResponseSizeBuckets []float64
+
+	// RequestDurationBuckets, if not nil, overrides the default buckets
+	// used for the request_duration_seconds histogram.
+	RequestDurationBuckets []float64
+
+	// SummaryObjectives, if not nil, additionally registers a SummaryVec
+	// companion, suffixed "_summary_{bytes,seconds}", for each of the
+	// request_size, response_size, and request_duration histograms, using
+	// these quantile objectives (e.g. {0.5: 0.05, 0.9: 0.01, 0.99: 0.001}).
+	// Summaries provide accurate tail-latency quantiles that wide histogram
+	// buckets cannot.
+	SummaryObjectives map[float64]float64
+
+	// DisableRCodeLabel, if true, omits the "rcode" label from the
+	// request_duration_seconds histogram (and its summary companion, if
+	// any), for operators concerned about its cardinality.
+	DisableRCodeLabel bool
+
+	// DisableSizeHistograms, if true, disables the request_size_bytes and
+	// response_size_bytes histograms (and their summary companions, if
+	// any), following the node_exporter --no-collector.<name> pattern for
+	// operators who find the Observe overhead not worth the data on their
+	// traffic volume.
+	DisableSizeHistograms bool
+
+	// DisableRCodeCounter, if true, disables the response_rcode_total
+	// counter.
+	DisableRCodeCounter bool
+
+	// DisableQUICAddrValidationCounter, if true, disables the
+	// quic_addr_validation_lookups counter.
+	DisableQUICAddrValidationCounter bool
+
+	// Registerer is used to register all metrics created by
+	// [NewServerMetricsListener].  If nil, [prometheus.DefaultRegisterer] is
+	// used.  Tests and callers that construct more than one
+	// *ServerMetricsListener in the same process must set this to a
+	// dedicated registerer, such as one backed by [prometheus.NewRegistry],
+	// to avoid a duplicate-registration panic.
+	Registerer prometheus.Registerer
+}
+
+// defaultSizeBuckets are the default buckets for the request_size_bytes and
+// response_size_bytes histograms.
+var defaultSizeBuckets = []float64{0, 50, 100, 200, 300, 511, 1023, 4095, 8291}
+
+// NewServerMetricsListener returns a new properly initialized
+// *ServerMetricsListener.  cfg may be nil, in which case the previous
+// hard-coded defaults are used.
+func NewServerMetricsListener(cfg *Config) (l *ServerMetricsListener) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+
+	reg := registererOrDefault(cfg)
+
+	sizeBuckets := cfg.RequestSizeBuckets
+	if sizeBuckets == nil {
+		sizeBuckets = defaultSizeBuckets
+	}
+
+	respSizeBuckets := cfg.ResponseSizeBuckets
+	if respSizeBuckets == nil {
+		respSizeBuckets = defaultSizeBuckets
+	}
+
+	durationBuckets := cfg.RequestDurationBuckets
+	if durationBuckets == nil {
+		durationBuckets = prometheus.DefBuckets
+	}
+
+	l = &ServerMetricsListener{
+		disableRCodeLabel: cfg.DisableRCodeLabel,
+		requestDuration: promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+			Name:      "request_duration_seconds",
+			Namespace: namespace,
+			Subsystem: subsystemServer,
+			Help:      "Time elapsed on processing a DNS query.",
+			Buckets:   durationBuckets,
+		}, []string{"name", "proto", "addr", "rcode"}),
+		requestSize:    newSizeRecorder(cfg, "request_size_bytes", "request_size_summary_bytes", "requests", sizeBuckets),
+		responseSize:   newSizeRecorder(cfg, "response_size_bytes", "response_size_summary_bytes", "responses", respSizeBuckets),
+		rCodeCounter:   newRCodeRecorder(cfg),
+		quicValidation: newQUICValidationRecorder(cfg),
+		requestTotal: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name:      "request_total",
+			Namespace: namespace,
+			Subsystem: subsystemServer,
+			Help:      "The number of processed DNS requests.",
+		}, []string{"name", "proto", "network", "addr", "type", "family"}),
+		requestsInFlight: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name:      "requests_in_flight",
+			Namespace: namespace,
+			Subsystem: subsystemServer,
+			Help:      "The number of DNS requests currently being processed.",
+		}, []string{"name", "proto", "addr"}),
+		errorTotal: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name:      "error_total",
+			Namespace: namespace,
+			Subsystem: subsystemServer,
+			Help:      "The number of errors occurred in the DNS server.",
+		}, []string{"name", "proto", "addr"}),
+		panicTotal: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name:      "panic_total",
+			Namespace: namespace,
+			Subsystem: subsystemServer,
+			Help:      "The number of panics occurred in the DNS server.",
+		}, []string{"name", "proto", "addr"}),
+		invalidMsgTotal: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name:      "invalid_msg_total",
+			Namespace: namespace,
+			Subsystem: subsystemServer,
+			Help:      "The number of invalid DNS messages processed by the DNS server.",
+		}, []string{"name", "proto", "addr"}),
+		requestType: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name:      "request_type_total",
+			Namespace: namespace,
+			Subsystem: subsystemServer,
+			Help:      "The number of processed DNS requests, labeled by query type.",
+		}, []string{"name", "proto", "addr", "qtype"}),
+		requestDO: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name:      "request_do_total",
+			Namespace: namespace,
+			Subsystem: subsystemServer,
+			Help:      "The number of processed DNS requests with the DO (DNSSEC OK) bit set.",
+		}, []string{"name", "proto", "addr"}),
+		requestEDNS0: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name:      "request_edns0_total",
+			Namespace: namespace,
+			Subsystem: subsystemServer,
+			Help:      "The number of processed DNS requests with EDNS0, labeled by advertised UDP buffer size bucket.",
+		}, []string{"name", "proto", "addr", "size_bucket"}),
+		responseFlags: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name:      "response_flags_total",
+			Namespace: namespace,
+			Subsystem: subsystemServer,
+			Help:      "The number of DNS responses, labeled by the header flags set on them.",
+		}, []string{"name", "proto", "addr", "flag"}),
+	}
+
+	if cfg.SummaryObjectives != nil {
+		l.requestDurationSummary = promauto.With(reg).NewSummaryVec(prometheus.SummaryOpts{
+			Name:       "request_duration_summary_seconds",
+			Namespace:  namespace,
+			Subsystem:  subsystemServer,
+			Help:       "Time elapsed on processing a DNS query, as a summary of quantiles.",
+			Objectives: cfg.SummaryObjectives,
+		}, []string{"name", "proto", "addr", "rcode"})
+	}
+
+	return l
+}
+
+// OnRequestStart implements the [dnsserver.MetricsListener] interface for
+// [*ServerMetricsListener].  It increments requestsInFlight; the matching
+// decrement happens in OnRequest.  The server must dispatch requests through
+// [dnsserver.WithRequestStartMetrics] for this to be called — without that,
+// requestsInFlight only ever sees OnRequest's Dec and drifts negative.
+func (l *ServerMetricsListener) OnRequestStart(ctx context.Context) {
+	serverInfo := dnsserver.MustServerInfoFromContext(ctx)
+	gaugeWithServerLabels(serverInfo, l.requestsInFlight).Inc()
+}
+
 // OnRequest implements the [dnsserver.MetricsListener] interface for
 // [*ServerMetricsListener].
 func (l *ServerMetricsListener) OnRequest(
@@ -27,131 +222,193 @@ func (l *ServerMetricsListener) OnRequest(
 	serverInfo := dnsserver.MustServerInfoFromContext(ctx)
 	startTime := dnsserver.MustStartTimeFromContext(ctx)
 
-	// Increment total requests count metrics.
-	counterWithRequestLabels(serverInfo, req, rw, requestTotal).Inc()
+	gaugeWithServerLabels(serverInfo, l.requestsInFlight).Dec()
 
-	// Increment request duration histogram.
-	elapsed := time.Since(startTime).Seconds()
-	histogramWithServerLabels(serverInfo, requestDuration).Observe(elapsed)
+	// Increment total requests count metrics.
+	counterWithRequestLabels(serverInfo, req, rw, l.requestTotal).Inc()
 
 	// Increment request size.
 	ri := dnsserver.MustRequestInfoFromContext(ctx)
-	histogramWithServerLabels(serverInfo, requestSize).Observe(float64(ri.RequestSize))
+	l.requestSize.Observe(serverInfo, float64(ri.RequestSize))
 
 	// If resp is not nil, increment response-related metrics.
+	var rCode string
 	if resp != nil {
-		histogramWithServerLabels(serverInfo, responseSize).Observe(float64(ri.ResponseSize))
-		rCode := rCodeToString(resp.Rcode)
-		counterWithServerLabelsPlusRCode(serverInfo, rCode, responseRCode).Inc()
+		l.responseSize.Observe(serverInfo, float64(ri.ResponseSize))
+
+		rCode = rCodeToString(resp.Rcode)
+		l.rCodeCounter.Inc(serverInfo, rCode)
+		l.incResponseFlags(serverInfo, resp)
 	} else {
 		// If resp is nil, increment responseRCode with a special "rcode"
 		// label value ("DROPPED").
-		counterWithServerLabelsPlusRCode(serverInfo, "DROPPED", responseRCode).Inc()
+		rCode = "DROPPED"
+		l.rCodeCounter.Inc(serverInfo, rCode)
+	}
+
+	// Increment request duration histogram (and summary, if enabled).  The
+	// "rcode" label is collapsed to a single constant value when
+	// disableRCodeLabel is set, since operators who set it are doing so to
+	// bound cardinality.
+	elapsed := time.Since(startTime).Seconds()
+	durationRCode := rCode
+	if l.disableRCodeLabel {
+		durationRCode = ""
+	}
+	histogramWithServerLabelsPlusRCode(serverInfo, durationRCode, l.requestDuration).Observe(elapsed)
+	if l.requestDurationSummary != nil {
+		summaryWithServerLabelsPlusRCode(serverInfo, durationRCode, l.requestDurationSummary).Observe(elapsed)
+	}
+
+	// Increment the per-QTYPE, EDNS0, and DO-bit breakdown metrics.
+	l.incQTypeAndEDNS(serverInfo, req)
+}
+
+// incQTypeAndEDNS increments the per-QTYPE and EDNS0/DO breakdown metrics for
+// req.
+func (l *ServerMetricsListener) incQTypeAndEDNS(serverInfo *dnsserver.ServerInfo, req *dns.Msg) {
+	if len(req.Question) > 0 {
+		qType := qTypeToString(req.Question[0].Qtype)
+		counterWithServerLabelsPlusQType(serverInfo, qType, l.requestType).Inc()
+	}
+
+	opt := req.IsEdns0()
+	if opt == nil {
+		return
+	}
+
+	if opt.Do() {
+		counterWithServerLabels(serverInfo, l.requestDO).Inc()
 	}
+
+	bucket := udpSizeBucket(opt.UDPSize())
+	counterWithServerLabelsPlusExtra(serverInfo, bucket, l.requestEDNS0).Inc()
 }
 
 // OnInvalidMsg implements the [dnsserver.MetricsListener] interface for
 // [*ServerMetricsListener].
 func (l *ServerMetricsListener) OnInvalidMsg(ctx context.Context) {
-	counterWithServerLabels(dnsserver.MustServerInfoFromContext(ctx), invalidMsgTotal).Inc()
+	counterWithServerLabels(dnsserver.MustServerInfoFromContext(ctx), l.invalidMsgTotal).Inc()
 }
 
 // OnError implements the [dnsserver.MetricsListener] interface for
 // [*ServerMetricsListener].
 func (l *ServerMetricsListener) OnError(ctx context.Context, _ error) {
-	counterWithServerLabels(dnsserver.MustServerInfoFromContext(ctx), errorTotal).Inc()
+	counterWithServerLabels(dnsserver.MustServerInfoFromContext(ctx), l.errorTotal).Inc()
 }
 
 // OnPanic implements the [dnsserver.MetricsListener] interface for
 // [*ServerMetricsListener].
 func (l *ServerMetricsListener) OnPanic(ctx context.Context, _ any) {
-	counterWithServerLabels(dnsserver.MustServerInfoFromContext(ctx), panicTotal).Inc()
+	counterWithServerLabels(dnsserver.MustServerInfoFromContext(ctx), l.panicTotal).Inc()
 }
 
 // OnQUICAddressValidation implements the [dnsserver.MetricsListener] interface
 // for [*ServerMetricsListener].
 func (l *ServerMetricsListener) OnQUICAddressValidation(hit bool) {
-	if hit {
-		quicAddrValidationCacheLookupsHits.Inc()
-	} else {
-		quicAddrValidationCacheLookupsMisses.Inc()
-	}
-}
-
-// This block contains prometheus metrics declarations for [dnsserver.Server]
-var (
-	requestTotal = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name:      "request_total",
-		Namespace: namespace,
-		Subsystem: subsystemServer,
-		Help:      "The number of processed DNS requests.",
-	}, []string{"name", "proto", "network", "addr", "type", "family"})
-
-	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
-		Name:      "request_duration_seconds",
-		Namespace: namespace,
-		Subsystem: subsystemServer,
-		Help:      "Time elapsed on processing a DNS query.",
-	}, []string{"name", "proto", "addr"})
-
-	requestSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
-		Name:      "request_size_bytes",
-		Namespace: namespace,
-		Subsystem: subsystemServer,
-		Help:      "Time elapsed on processing a DNS query.",
-		Buckets: []float64{
-			0, 50, 100, 200, 300, 511, 1023, 4095, 8291,
-		},
-	}, []string{"name", "proto", "addr"})
-
-	responseSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
-		Name:      "response_size_bytes",
-		Namespace: namespace,
-		Subsystem: subsystemServer,
-		Help:      "Time elapsed on processing a DNS query.",
-		Buckets: []float64{
-			0, 50, 100, 200, 300, 511, 1023, 4095, 8291,
-		},
-	}, []string{"name", "proto", "addr"})
-
-	responseRCode = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name:      "response_rcode_total",
-		Namespace: namespace,
-		Subsystem: subsystemServer,
-		Help:      "The counter for DNS response codes.",
-	}, []string{"name", "proto", "addr", "rcode"})
-
-	errorTotal = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name:      "error_total",
-		Namespace: namespace,
-		Subsystem: subsystemServer,
-		Help:      "The number of errors occurred in the DNS server.",
-	}, []string{"name", "proto", "addr"})
-
-	panicTotal = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name:      "panic_total",
-		Namespace: namespace,
-		Subsystem: subsystemServer,
-		Help:      "The number of panics occurred in the DNS server.",
-	}, []string{"name", "proto", "addr"})
-
-	invalidMsgTotal = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name:      "invalid_msg_total",
-		Namespace: namespace,
-		Subsystem: subsystemServer,
-		Help:      "The number of invalid DNS messages processed by the DNS server.",
-	}, []string{"name", "proto", "addr"})
-)
+	l.quicValidation.Record(hit)
+}
 
-var (
-	quicAddrValidationCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name:      "quic_addr_validation_lookups",
-		Namespace: namespace,
-		Subsystem: subsystemServer,
-		Help: "The number of QUIC address validation lookups." +
-			"hit=1 means that a cached item was found.",
-	}, []string{"hit"})
-
-	quicAddrValidationCacheLookupsHits   = quicAddrValidationCacheLookups.WithLabelValues("1")
-	quicAddrValidationCacheLookupsMisses = quicAddrValidationCacheLookups.WithLabelValues("0")
-)
+// udpSizeBucket maps an advertised EDNS0 UDP buffer size to a bounded set of
+// bucket labels.
+func udpSizeBucket(size uint16) (bucket string) {
+	switch {
+	case size == 0:
+		return "0"
+	case size <= 512:
+		return "512"
+	case size <= 1232:
+		return "1232"
+	case size <= 4096:
+		return "4096"
+	default:
+		return "4096+"
+	}
+}
+
+// qTypeToString returns the string representation of qType, or "UNKNOWN" if
+// qType has no known name, so as to keep the qtype label's cardinality
+// bounded.
+func qTypeToString(qType uint16) (s string) {
+	s, ok := dns.TypeToString[qType]
+	if !ok {
+		return "UNKNOWN"
+	}
+
+	return s
+}
+
+// gaugeWithServerLabels returns the gauge from m with the labels from
+// serverInfo.
+func gaugeWithServerLabels(
+	serverInfo *dnsserver.ServerInfo,
+	m *prometheus.GaugeVec,
+) (gauge prometheus.Gauge) {
+	return m.WithLabelValues(serverInfo.Name, serverInfo.Proto.String(), serverInfo.Addr)
+}
+
+// histogramWithServerLabelsPlusRCode returns the histogram from m with the
+// labels from serverInfo plus rCode.
+func histogramWithServerLabelsPlusRCode(
+	serverInfo *dnsserver.ServerInfo,
+	rCode string,
+	m *prometheus.HistogramVec,
+) (histogram prometheus.Observer) {
+	return m.WithLabelValues(serverInfo.Name, serverInfo.Proto.String(), serverInfo.Addr, rCode)
+}
+
+// summaryWithServerLabels returns the summary from m with the labels from
+// serverInfo.
+func summaryWithServerLabels(
+	serverInfo *dnsserver.ServerInfo,
+	m *prometheus.SummaryVec,
+) (summary prometheus.Observer) {
+	return m.WithLabelValues(serverInfo.Name, serverInfo.Proto.String(), serverInfo.Addr)
+}
+
+// summaryWithServerLabelsPlusRCode returns the summary from m with the
+// labels from serverInfo plus rCode.
+func summaryWithServerLabelsPlusRCode(
+	serverInfo *dnsserver.ServerInfo,
+	rCode string,
+	m *prometheus.SummaryVec,
+) (summary prometheus.Observer) {
+	return m.WithLabelValues(serverInfo.Name, serverInfo.Proto.String(), serverInfo.Addr, rCode)
+}
+
+// counterWithServerLabelsPlusQType returns the counter from m with the
+// labels from serverInfo plus qType.
+func counterWithServerLabelsPlusQType(
+	serverInfo *dnsserver.ServerInfo,
+	qType string,
+	m *prometheus.CounterVec,
+) (counter prometheus.Counter) {
+	return m.WithLabelValues(serverInfo.Name, serverInfo.Proto.String(), serverInfo.Addr, qType)
+}
+
+// counterWithServerLabelsPlusExtra returns the counter from m with the
+// labels from serverInfo plus bucket.
+func counterWithServerLabelsPlusExtra(
+	serverInfo *dnsserver.ServerInfo,
+	bucket string,
+	m *prometheus.CounterVec,
+) (counter prometheus.Counter) {
+	return m.WithLabelValues(serverInfo.Name, serverInfo.Proto.String(), serverInfo.Addr, bucket)
+}
+
+// incResponseFlags increments l.responseFlags once for each of the AA, TC,
+// AD, and RA header flags that are set on resp.
+func (l *ServerMetricsListener) incResponseFlags(serverInfo *dnsserver.ServerInfo, resp *dns.Msg) {
+	if resp.Authoritative {
+		counterWithServerLabelsPlusExtra(serverInfo, "AA", l.responseFlags).Inc()
+	}
+	if resp.Truncated {
+		counterWithServerLabelsPlusExtra(serverInfo, "TC", l.responseFlags).Inc()
+	}
+	if resp.AuthenticatedData {
+		counterWithServerLabelsPlusExtra(serverInfo, "AD", l.responseFlags).Inc()
+	}
+	if resp.RecursionAvailable {
+		counterWithServerLabelsPlusExtra(serverInfo, "RA", l.responseFlags).Inc()
+	}
+}
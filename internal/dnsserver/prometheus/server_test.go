@@ -0,0 +1,113 @@
+package prometheus
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUDPSizeBucket(t *testing.T) {
+	testCases := []struct {
+		name string
+		size uint16
+		want string
+	}{{
+		name: "zero",
+		size: 0,
+		want: "0",
+	}, {
+		name: "at_512",
+		size: 512,
+		want: "512",
+	}, {
+		name: "just_above_512",
+		size: 513,
+		want: "1232",
+	}, {
+		name: "at_1232",
+		size: 1232,
+		want: "1232",
+	}, {
+		name: "at_4096",
+		size: 4096,
+		want: "4096",
+	}, {
+		name: "above_4096",
+		size: 4097,
+		want: "4096+",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, udpSizeBucket(tc.size))
+		})
+	}
+}
+
+func TestQTypeToString(t *testing.T) {
+	assert.Equal(t, "A", qTypeToString(dns.TypeA))
+	assert.Equal(t, "AAAA", qTypeToString(dns.TypeAAAA))
+	assert.Equal(t, "UNKNOWN", qTypeToString(65534))
+}
+
+// TestNewServerMetricsListener_buckets makes sure that RequestDurationBuckets
+// and RequestSizeBuckets actually configure the registered histograms,
+// rather than being silently ignored in favor of the hard-coded defaults.
+func TestNewServerMetricsListener_buckets(t *testing.T) {
+	wantDurationBuckets := []float64{0.1, 0.2, 0.5}
+	wantSizeBuckets := []float64{10, 20}
+
+	l := NewServerMetricsListener(&Config{
+		Registerer:             prometheus.NewRegistry(),
+		RequestDurationBuckets: wantDurationBuckets,
+		RequestSizeBuckets:     wantSizeBuckets,
+	})
+
+	m := &dto.Metric{}
+	require.NoError(t, l.requestDuration.WithLabelValues("n", "p", "a", "rcode").(prometheus.Histogram).Write(m))
+	assert.Len(t, m.GetHistogram().GetBucket(), len(wantDurationBuckets))
+	for i, b := range m.GetHistogram().GetBucket() {
+		assert.Equal(t, wantDurationBuckets[i], b.GetUpperBound())
+	}
+
+	sizeRecorder, ok := l.requestSize.(*prometheusSizeRecorder)
+	require.True(t, ok)
+
+	m = &dto.Metric{}
+	require.NoError(t, sizeRecorder.hist.WithLabelValues("n", "p", "a").(prometheus.Histogram).Write(m))
+	assert.Len(t, m.GetHistogram().GetBucket(), len(wantSizeBuckets))
+	for i, b := range m.GetHistogram().GetBucket() {
+		assert.Equal(t, wantSizeBuckets[i], b.GetUpperBound())
+	}
+}
+
+// TestNewServerMetricsListener_summaryObjectives makes sure that setting
+// SummaryObjectives actually causes a summary companion to be created and
+// observed alongside the histogram.
+func TestNewServerMetricsListener_summaryObjectives(t *testing.T) {
+	objectives := map[float64]float64{0.5: 0.05, 0.99: 0.001}
+
+	l := NewServerMetricsListener(&Config{
+		Registerer:        prometheus.NewRegistry(),
+		SummaryObjectives: objectives,
+	})
+	require.NotNil(t, l.requestDurationSummary)
+
+	l.requestDurationSummary.WithLabelValues("n", "p", "a", "rcode").Observe(0.1)
+
+	m := &dto.Metric{}
+	require.NoError(t, l.requestDurationSummary.
+		WithLabelValues("n", "p", "a", "rcode").(prometheus.Summary).
+		Write(m))
+
+	gotQuantiles := m.GetSummary().GetQuantile()
+	require.Len(t, gotQuantiles, len(objectives))
+	for _, q := range gotQuantiles {
+		_, ok := objectives[q.GetQuantile()]
+		assert.True(t, ok)
+	}
+}
@@ -0,0 +1,200 @@
+// Package dnstap implements a [dnsserver.MetricsListener] that streams DNS
+// query and response events to an external analyzer (ClickHouse, Kafka, an
+// offline miner, etc.) using the dnstap framestream protocol, in addition to
+// (not instead of) the Prometheus metrics exposed by
+// [prometheus.ServerMetricsListener].
+//
+// Dnstap events are delivered asynchronously through a bounded queue so that
+// a slow or unavailable consumer never blocks DNS handling; once the queue is
+// full, new events are dropped and counted in the dnstap_dropped_total
+// metric.
+package dnstap
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardDNS/internal/dnsserver"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/dnstap/golang-dnstap"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// defaultQueueSize is the default size of the buffered channel used to
+// deliver dnstap messages to the writer goroutine.
+const defaultQueueSize = 1000
+
+// Config is the configuration of a dnstap [Listener].
+type Config struct {
+	// Network is the network the dnstap transport dials, either "unix" or
+	// "tcp".
+	Network string
+
+	// Address is the address the dnstap transport dials: a socket path
+	// for the "unix" network, or a "host:port" pair for "tcp".
+	Address string
+
+	// Identity is the value of the outgoing messages' identity field.  If
+	// empty, the identity field is left unset.
+	Identity string
+
+	// QueueSize is the maximum number of unwritten messages that may be
+	// buffered before new ones are dropped.  If zero, [defaultQueueSize]
+	// is used.
+	QueueSize int
+}
+
+// Listener implements the [dnsserver.MetricsListener] interface and emits
+// dnstap events over a framestream [Transport].
+type Listener struct {
+	transport Transport
+	identity  []byte
+	msgCh     chan *dnstap.Dnstap
+
+	// closeMu protects closed and serializes it with concurrent calls to
+	// send, so that send never writes to msgCh after it has been closed.
+	closeMu sync.Mutex
+	closed  bool
+}
+
+// type check
+var _ dnsserver.MetricsListener = (*Listener)(nil)
+
+// NewListener returns a new properly initialized *Listener.  It also starts
+// the asynchronous write loop; call [Listener.Close] to stop it.
+func NewListener(conf *Config) (l *Listener, err error) {
+	queueSize := conf.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+
+	tr, err := newTransport(conf.Network, conf.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	l = &Listener{
+		transport: tr,
+		msgCh:     make(chan *dnstap.Dnstap, queueSize),
+	}
+	if conf.Identity != "" {
+		l.identity = []byte(conf.Identity)
+	}
+
+	go l.writeLoop()
+
+	return l, nil
+}
+
+// Close closes the underlying transport and stops the write loop.  Close is
+// safe for concurrent use with OnRequest/OnInvalidMsg; it implements the
+// io.Closer interface for *Listener.
+func (l *Listener) Close() (err error) {
+	l.closeMu.Lock()
+	defer l.closeMu.Unlock()
+
+	if l.closed {
+		return nil
+	}
+
+	l.closed = true
+	close(l.msgCh)
+
+	return l.transport.Close()
+}
+
+// OnRequestStart implements the [dnsserver.MetricsListener] interface for
+// *Listener.  Dnstap has no use for the in-flight gauge that this hook
+// exists for, so this is a no-op.
+func (l *Listener) OnRequestStart(_ context.Context) {}
+
+// OnRequest implements the [dnsserver.MetricsListener] interface for
+// *Listener.  It emits a CLIENT_QUERY event for req and, if resp is not nil,
+// a CLIENT_RESPONSE event for resp.
+func (l *Listener) OnRequest(
+	ctx context.Context,
+	req, resp *dns.Msg,
+	rw dnsserver.ResponseWriter,
+) {
+	serverInfo := dnsserver.MustServerInfoFromContext(ctx)
+	startTime := dnsserver.MustStartTimeFromContext(ctx)
+
+	sf, proto := socketParams(serverInfo, rw)
+
+	queryAddr, respAddr := rw.RemoteAddr(), rw.LocalAddr()
+
+	l.send(l.message(dnstap.Message_CLIENT_QUERY, sf, proto, req, startTime, queryAddr, respAddr))
+	if resp != nil {
+		l.send(l.message(dnstap.Message_CLIENT_RESPONSE, sf, proto, resp, time.Now(), queryAddr, respAddr))
+	}
+}
+
+// OnInvalidMsg implements the [dnsserver.MetricsListener] interface for
+// *Listener.  Since the message failed to parse, there is no *dns.Msg to
+// wrap, so it emits a minimal CLIENT_QUERY event carrying only the query
+// timestamp and identity, with no embedded DNS message.
+func (l *Listener) OnInvalidMsg(ctx context.Context) {
+	startTime := dnsserver.MustStartTimeFromContext(ctx)
+
+	l.send(l.minimalMessage(startTime))
+}
+
+// OnError implements the [dnsserver.MetricsListener] interface for *Listener.
+func (l *Listener) OnError(_ context.Context, _ error) {}
+
+// OnPanic implements the [dnsserver.MetricsListener] interface for *Listener.
+func (l *Listener) OnPanic(_ context.Context, _ any) {}
+
+// OnQUICAddressValidation implements the [dnsserver.MetricsListener]
+// interface for *Listener.
+func (l *Listener) OnQUICAddressValidation(_ bool) {}
+
+// send enqueues m for delivery, incrementing dnstapDroppedTotal if the queue
+// is full or the listener has been closed.
+func (l *Listener) send(m *dnstap.Dnstap) {
+	l.closeMu.Lock()
+	defer l.closeMu.Unlock()
+
+	if l.closed {
+		dnstapDroppedTotal.Inc()
+
+		return
+	}
+
+	select {
+	case l.msgCh <- m:
+	default:
+		dnstapDroppedTotal.Inc()
+	}
+}
+
+// writeLoop reads messages off msgCh and writes them to the transport,
+// reconnecting on error.  writeLoop is intended to be used as a goroutine.
+func (l *Listener) writeLoop() {
+	for m := range l.msgCh {
+		data, err := m.Marshal()
+		if err != nil {
+			log.Error("dnstap: marshaling message: %s", err)
+
+			continue
+		}
+
+		if err = l.transport.Write(data); err != nil {
+			log.Error("dnstap: writing message, reconnecting: %s", err)
+			dnstapDroppedTotal.Inc()
+		}
+	}
+}
+
+// This block contains the Prometheus metrics for package dnstap.
+var (
+	dnstapDroppedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name:      "dnstap_dropped_total",
+		Namespace: "dns",
+		Subsystem: "dnstap",
+		Help:      "The number of dnstap messages dropped due to a full queue or a write error.",
+	})
+)
@@ -0,0 +1,138 @@
+package dnstap
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardDNS/internal/dnsserver"
+	"github.com/dnstap/golang-dnstap"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// errTestWrite is returned by [fakeTransport.Write] when it's configured to
+// fail.
+var errTestWrite = errors.New("dnstap: test write error")
+
+// fakeTransport is a [Transport] that records writes in memory for use in
+// tests, optionally failing every Write.
+type fakeTransport struct {
+	mu      sync.Mutex
+	writes  [][]byte
+	failAll bool
+}
+
+// type check
+var _ Transport = (*fakeTransport)(nil)
+
+func (t *fakeTransport) Write(data []byte) (err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.failAll {
+		return errTestWrite
+	}
+
+	t.writes = append(t.writes, data)
+
+	return nil
+}
+
+func (t *fakeTransport) Close() (err error) { return nil }
+
+func (t *fakeTransport) writeCount() (n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.writes)
+}
+
+// newTestListener returns a *Listener using tr as its transport, bypassing
+// [NewListener]'s network dial.
+func newTestListener(tr Transport) (l *Listener) {
+	l = &Listener{
+		transport: tr,
+		msgCh:     make(chan *dnstap.Dnstap, 10),
+	}
+	go l.writeLoop()
+
+	return l
+}
+
+func TestListener_OnInvalidMsg(t *testing.T) {
+	tr := &fakeTransport{}
+	l := newTestListener(tr)
+	t.Cleanup(func() { _ = l.Close() })
+
+	ctx := dnsserver.ContextWithStartTime(context.Background(), time.Now())
+	l.OnInvalidMsg(ctx)
+
+	require.Eventually(t, func() bool {
+		return tr.writeCount() == 1
+	}, 1*time.Second, 1*time.Millisecond)
+}
+
+func TestListener_Close_concurrentSend(t *testing.T) {
+	l := newTestListener(&fakeTransport{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ctx := dnsserver.ContextWithStartTime(context.Background(), time.Now())
+			l.OnInvalidMsg(ctx)
+		}()
+	}
+
+	assert.NotPanics(t, func() {
+		_ = l.Close()
+	})
+
+	wg.Wait()
+}
+
+func TestListener_send_dropsOnFullQueue(t *testing.T) {
+	before := testutil.ToFloat64(dnstapDroppedTotal)
+
+	l := &Listener{
+		transport: &fakeTransport{},
+		msgCh:     make(chan *dnstap.Dnstap),
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	l.send(&dnstap.Dnstap{})
+
+	after := testutil.ToFloat64(dnstapDroppedTotal)
+	assert.Equal(t, before+1, after)
+}
+
+func TestListener_writeLoop_dropsOnWriteError(t *testing.T) {
+	before := testutil.ToFloat64(dnstapDroppedTotal)
+
+	l := newTestListener(&fakeTransport{failAll: true})
+	t.Cleanup(func() { _ = l.Close() })
+
+	l.send(&dnstap.Dnstap{})
+
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(dnstapDroppedTotal) == before+1
+	}, 1*time.Second, 1*time.Millisecond)
+}
+
+func TestListener_send_afterClose(t *testing.T) {
+	before := testutil.ToFloat64(dnstapDroppedTotal)
+
+	l := newTestListener(&fakeTransport{})
+	require.NoError(t, l.Close())
+
+	l.send(&dnstap.Dnstap{})
+
+	after := testutil.ToFloat64(dnstapDroppedTotal)
+	assert.Equal(t, before+1, after)
+}
@@ -0,0 +1,124 @@
+package dnstap
+
+import (
+	"net"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardDNS/internal/dnsserver"
+	"github.com/dnstap/golang-dnstap"
+	"github.com/miekg/dns"
+)
+
+// message returns a new dnstap envelope of the given type built from msg.
+func (l *Listener) message(
+	typ dnstap.Message_Type,
+	sf dnstap.SocketFamily,
+	proto dnstap.SocketProtocol,
+	msg *dns.Msg,
+	when time.Time,
+	queryAddr, respAddr net.Addr,
+) (m *dnstap.Dnstap) {
+	wire, err := msg.Pack()
+	if err != nil {
+		// The message has already been validated by the DNS server, so this
+		// should essentially never happen.  Send an empty payload rather
+		// than dropping the event outright.
+		wire = nil
+	}
+
+	sec := uint64(when.Unix())
+	nsec := uint32(when.Nanosecond())
+
+	dm := &dnstap.Message{
+		Type:           &typ,
+		SocketFamily:   &sf,
+		SocketProtocol: &proto,
+	}
+
+	switch typ {
+	case dnstap.Message_CLIENT_QUERY:
+		dm.QueryTimeSec, dm.QueryTimeNsec = &sec, &nsec
+		dm.QueryMessage = wire
+		dm.QueryAddress, dm.QueryPort = addrParts(queryAddr)
+		dm.ResponseAddress, dm.ResponsePort = addrParts(respAddr)
+	default:
+		dm.ResponseTimeSec, dm.ResponseTimeNsec = &sec, &nsec
+		dm.ResponseMessage = wire
+		dm.QueryAddress, dm.QueryPort = addrParts(queryAddr)
+		dm.ResponseAddress, dm.ResponsePort = addrParts(respAddr)
+	}
+
+	m = &dnstap.Dnstap{
+		Type:    dnstap.Dnstap_MESSAGE.Enum(),
+		Message: dm,
+	}
+	if l.identity != nil {
+		m.Identity = l.identity
+	}
+
+	return m
+}
+
+// minimalMessage returns a new dnstap envelope carrying only a query
+// timestamp and identity, for use when no parsed *dns.Msg is available (for
+// example, for a message that failed to parse).
+func (l *Listener) minimalMessage(when time.Time) (m *dnstap.Dnstap) {
+	typ := dnstap.Message_CLIENT_QUERY
+	sec := uint64(when.Unix())
+	nsec := uint32(when.Nanosecond())
+
+	m = &dnstap.Dnstap{
+		Type: dnstap.Dnstap_MESSAGE.Enum(),
+		Message: &dnstap.Message{
+			Type:          &typ,
+			QueryTimeSec:  &sec,
+			QueryTimeNsec: &nsec,
+		},
+	}
+	if l.identity != nil {
+		m.Identity = l.identity
+	}
+
+	return m
+}
+
+// addrParts splits addr into its raw IP bytes and port, as required by the
+// dnstap Message fields.  It returns nil, nil if addr is nil or not a
+// *net.UDPAddr/*net.TCPAddr.
+func addrParts(addr net.Addr) (ip []byte, port *uint32) {
+	var ipAddr net.IP
+	var p int
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ipAddr, p = a.IP, a.Port
+	case *net.TCPAddr:
+		ipAddr, p = a.IP, a.Port
+	default:
+		return nil, nil
+	}
+
+	portVal := uint32(p)
+
+	return []byte(ipAddr), &portVal
+}
+
+// socketParams derives the dnstap socket family and protocol from si and rw.
+func socketParams(
+	si *dnsserver.ServerInfo,
+	rw dnsserver.ResponseWriter,
+) (sf dnstap.SocketFamily, proto dnstap.SocketProtocol) {
+	sf = dnstap.SocketFamily_INET
+	if a, ok := rw.LocalAddr().(*net.UDPAddr); ok && a.IP.To4() == nil {
+		sf = dnstap.SocketFamily_INET6
+	} else if a, ok := rw.LocalAddr().(*net.TCPAddr); ok && a.IP.To4() == nil {
+		sf = dnstap.SocketFamily_INET6
+	}
+
+	if si.Proto.Network() == "udp" {
+		proto = dnstap.SocketProtocol_UDP
+	} else {
+		proto = dnstap.SocketProtocol_TCP
+	}
+
+	return sf, proto
+}
@@ -0,0 +1,154 @@
+package dnstap
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/farsightsec/golang-framestream"
+)
+
+// reconnectDelay is the time to wait between reconnection attempts.
+const reconnectDelay = 1 * time.Second
+
+// Transport is a framestream transport used by a dnstap [Listener] to
+// deliver encoded messages to an external consumer.  Write is safe for
+// concurrent use.
+type Transport interface {
+	// Write encodes and writes data as a single frame, reconnecting in the
+	// background if the underlying connection is currently down.
+	Write(data []byte) (err error)
+
+	// Close closes the transport and any underlying connection.
+	Close() (err error)
+}
+
+// newTransport returns a new [Transport] dialing address over network,
+// which must be either "unix" or "tcp".
+func newTransport(network, address string) (t Transport, err error) {
+	switch network {
+	case "unix", "tcp":
+		// Go on.
+	default:
+		return nil, fmt.Errorf("dnstap: unsupported network %q", network)
+	}
+
+	tr := &reconnectTransport{
+		network: network,
+		address: address,
+	}
+	tr.connect()
+
+	return tr, nil
+}
+
+// reconnectTransport is a [Transport] that dials network/address on creation
+// and transparently reconnects, dropping frames written while disconnected,
+// until the connection is re-established.
+type reconnectTransport struct {
+	// mu protects enc and closed.
+	mu sync.Mutex
+
+	network string
+	address string
+
+	enc    *framestream.Encoder
+	conn   net.Conn
+	closed bool
+}
+
+// type check
+var _ Transport = (*reconnectTransport)(nil)
+
+// connect dials the transport's network/address and, on success, wraps the
+// connection in a framestream encoder using the dnstap content type.  It
+// logs and schedules a retry on failure.  connect takes mu itself to store
+// the new connection, so it must not be called with mu held.  If the
+// transport has been closed in the meantime, the new connection is closed
+// instead of stored.
+func (t *reconnectTransport) connect() {
+	conn, err := net.Dial(t.network, t.address)
+	if err != nil {
+		log.Error("dnstap: dialing %s %s: %s", t.network, t.address, err)
+		go t.reconnectLater()
+
+		return
+	}
+
+	enc, err := framestream.NewEncoder(conn, &framestream.EncoderOptions{
+		ContentType:   []byte("protobuf:dnstap.Dnstap"),
+		Bidirectional: true,
+	})
+	if err != nil {
+		log.Error("dnstap: creating framestream encoder: %s", err)
+		_ = conn.Close()
+		go t.reconnectLater()
+
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		_ = enc.Close()
+		_ = conn.Close()
+
+		return
+	}
+
+	t.conn, t.enc = conn, enc
+}
+
+// reconnectLater waits for [reconnectDelay] and then retries [connect],
+// unless the transport has since been closed.  reconnectLater is intended to
+// be used as a goroutine.
+func (t *reconnectTransport) reconnectLater() {
+	time.Sleep(reconnectDelay)
+
+	t.mu.Lock()
+	closed := t.closed
+	t.mu.Unlock()
+	if !closed {
+		t.connect()
+	}
+}
+
+// Write implements the [Transport] interface for *reconnectTransport.
+func (t *reconnectTransport) Write(data []byte) (err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.enc == nil {
+		return fmt.Errorf("dnstap: not connected")
+	}
+
+	if _, err = t.enc.Write(data); err != nil {
+		_ = t.conn.Close()
+		t.enc, t.conn = nil, nil
+
+		go t.reconnectLater()
+
+		return fmt.Errorf("dnstap: writing frame: %w", err)
+	}
+
+	return nil
+}
+
+// Close implements the [Transport] interface for *reconnectTransport.
+func (t *reconnectTransport) Close() (err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closed = true
+	if t.enc != nil {
+		_ = t.enc.Close()
+	}
+	if t.conn != nil {
+		err = t.conn.Close()
+	}
+
+	return err
+}